@@ -0,0 +1,206 @@
+// Package deniability implements a middleware.Hook that injects synthetic
+// peers into announce responses, giving the real peers plausible deniability
+// against observers harvesting peer lists from the tracker.
+package deniability
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware"
+	"github.com/chihaya/chihaya/middleware/pkg/random"
+)
+
+// ErrInvalidModifyResponseProbability is returned for a config with an
+// invalid ModifyResponseProbability.
+var ErrInvalidModifyResponseProbability = errors.New("invalid modify_response_probability")
+
+// ErrInvalidMaxRandomPeers is returned for a config with an invalid
+// MaxRandomPeers.
+var ErrInvalidMaxRandomPeers = errors.New("invalid max_random_peers")
+
+// ErrInvalidPortRange is returned for a config with an invalid MinPort or
+// MaxPort.
+var ErrInvalidPortRange = errors.New("invalid port range")
+
+// ErrInvalidPrefix is returned for a config whose Prefix is longer than the
+// 20 bytes of a bittorrent.PeerID.
+var ErrInvalidPrefix = errors.New("invalid prefix")
+
+// Config represents the configuration for the deniability middleware.
+type Config struct {
+	// ModifyResponseProbability is the probability by which a response will
+	// have synthetic peers injected into it.
+	ModifyResponseProbability float32 `yaml:"modify_response_probability"`
+
+	// MaxRandomPeers is the maximum number of synthetic peers that can be
+	// injected into a single response.
+	MaxRandomPeers int `yaml:"max_random_peers"`
+
+	// Prefix is prepended to the random suffix of every generated peer ID.
+	// It must be at most the 20 bytes of a bittorrent.PeerID; the bytes of
+	// the ID after it are filled with random values.
+	Prefix string `yaml:"prefix"`
+
+	// MinPort is the inclusive lower bound used when generating a port for
+	// a synthetic peer.
+	MinPort int `yaml:"min_port"`
+
+	// MaxPort is the exclusive upper bound used when generating a port for
+	// a synthetic peer.
+	MaxPort int `yaml:"max_port"`
+
+	// GenerateIPv4 enables generating synthetic peers with an IPv4 address.
+	GenerateIPv4 bool `yaml:"generate_ipv4"`
+
+	// GenerateIPv6 enables generating synthetic peers with an IPv6 address.
+	GenerateIPv6 bool `yaml:"generate_ipv6"`
+}
+
+func checkConfig(cfg Config) error {
+	if cfg.ModifyResponseProbability <= 0 || cfg.ModifyResponseProbability > 1 {
+		return ErrInvalidModifyResponseProbability
+	}
+
+	if cfg.MaxRandomPeers <= 0 {
+		return ErrInvalidMaxRandomPeers
+	}
+
+	if cfg.MinPort <= 0 || cfg.MaxPort <= cfg.MinPort || cfg.MaxPort > 1<<16 {
+		return ErrInvalidPortRange
+	}
+
+	if len(cfg.Prefix) > 20 {
+		return ErrInvalidPrefix
+	}
+
+	if !cfg.GenerateIPv4 && !cfg.GenerateIPv6 {
+		return errors.New("at least one of generate_ipv4 or generate_ipv6 must be enabled")
+	}
+
+	return nil
+}
+
+type hook struct {
+	cfg    Config
+	prefix [20]byte
+}
+
+// New creates a middleware that injects synthetic peers into announce
+// responses from the given config.
+func New(cfg Config) (middleware.Hook, error) {
+	err := checkConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &hook{cfg: cfg}
+	copy(h.prefix[:], cfg.Prefix)
+
+	return h, nil
+}
+
+func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
+	if req.NumWant == 0 {
+		return ctx, nil
+	}
+
+	s0, s1 := random.DeriveEntropyFromRequest(req)
+
+	v, s0, s1 := random.Intn(s0, s1, 1<<24)
+	p := float32(v) / (1 << 24)
+	if h.cfg.ModifyResponseProbability != 1 && p >= h.cfg.ModifyResponseProbability {
+		return ctx, nil
+	}
+
+	room := int(req.NumWant) - len(resp.IPv4Peers) - len(resp.IPv6Peers)
+	if room <= 0 {
+		return ctx, nil
+	}
+
+	var count int
+	count, s0, s1 = random.Intn(s0, s1, h.cfg.MaxRandomPeers)
+	count++
+	if count > room {
+		count = room
+	}
+
+	for i := 0; i < count; i++ {
+		var useV6 bool
+		switch {
+		case h.cfg.GenerateIPv4 && h.cfg.GenerateIPv6:
+			var v int
+			v, s0, s1 = random.Intn(s0, s1, 2)
+			useV6 = v == 1
+		case h.cfg.GenerateIPv6:
+			useV6 = true
+		default:
+			useV6 = false
+		}
+
+		var peer bittorrent.Peer
+		peer, s0, s1 = h.randomPeer(useV6, s0, s1)
+
+		if useV6 {
+			resp.IPv6Peers = append(resp.IPv6Peers, peer)
+		} else {
+			resp.IPv4Peers = append(resp.IPv4Peers, peer)
+		}
+	}
+
+	return ctx, nil
+}
+
+// randomPeer generates a single synthetic bittorrent.Peer, threading the RNG
+// state through every random draw so the result stays deterministic for a
+// given request.
+func (h *hook) randomPeer(useV6 bool, s0, s1 uint64) (bittorrent.Peer, uint64, uint64) {
+	family := bittorrent.IPv4
+	addrLen := net.IPv4len
+	if useV6 {
+		family = bittorrent.IPv6
+		addrLen = net.IPv6len
+	}
+
+	ip := make(net.IP, addrLen)
+	for i := range ip {
+		var b int
+		b, s0, s1 = random.Intn(s0, s1, 256)
+		ip[i] = byte(b)
+	}
+	if !useV6 {
+		ip = ip.To4()
+	}
+
+	var port int
+	port, s0, s1 = random.Intn(s0, s1, h.cfg.MaxPort-h.cfg.MinPort)
+	port += h.cfg.MinPort
+
+	var id bittorrent.PeerID
+	copy(id[:], h.prefix[:])
+	for i := len(h.cfg.Prefix); i < len(id); i++ {
+		var b int
+		b, s0, s1 = random.Intn(s0, s1, 256)
+		id[i] = byte(b)
+	}
+
+	peer := bittorrent.Peer{
+		ID:   id,
+		IP:   bittorrent.IP{IP: ip, AddressFamily: family},
+		Port: uint16(port),
+	}
+
+	return peer, s0, s1
+}
+
+func (h *hook) HandleScrape(ctx context.Context, req *bittorrent.ScrapeRequest, resp *bittorrent.ScrapeResponse) (context.Context, error) {
+	// Scrapes are not altered.
+	return ctx, nil
+}
+
+func (h *hook) HandleApi(ctx context.Context, req *bittorrent.ApiRequest, resp *bittorrent.ApiResponse) (context.Context, error) {
+	// Apis are not altered.
+	return ctx, nil
+}
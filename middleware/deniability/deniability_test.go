@@ -0,0 +1,181 @@
+package deniability
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func validConfig() Config {
+	return Config{
+		ModifyResponseProbability: 1,
+		MaxRandomPeers:            3,
+		Prefix:                    "-CH0001-",
+		MinPort:                   1024,
+		MaxPort:                   65535,
+		GenerateIPv4:              true,
+		GenerateIPv6:              true,
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	table := []struct {
+		name    string
+		mutate  func(cfg *Config)
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			mutate:  func(cfg *Config) {},
+			wantErr: nil,
+		},
+		{
+			name:    "probability zero",
+			mutate:  func(cfg *Config) { cfg.ModifyResponseProbability = 0 },
+			wantErr: ErrInvalidModifyResponseProbability,
+		},
+		{
+			name:    "probability too high",
+			mutate:  func(cfg *Config) { cfg.ModifyResponseProbability = 1.1 },
+			wantErr: ErrInvalidModifyResponseProbability,
+		},
+		{
+			name:    "max random peers zero",
+			mutate:  func(cfg *Config) { cfg.MaxRandomPeers = 0 },
+			wantErr: ErrInvalidMaxRandomPeers,
+		},
+		{
+			name:    "min port zero",
+			mutate:  func(cfg *Config) { cfg.MinPort = 0 },
+			wantErr: ErrInvalidPortRange,
+		},
+		{
+			name:    "max port not greater than min port",
+			mutate:  func(cfg *Config) { cfg.MinPort, cfg.MaxPort = 100, 100 },
+			wantErr: ErrInvalidPortRange,
+		},
+		{
+			name:    "max port too large",
+			mutate:  func(cfg *Config) { cfg.MaxPort = 1<<16 + 1 },
+			wantErr: ErrInvalidPortRange,
+		},
+		{
+			name:    "prefix too long",
+			mutate:  func(cfg *Config) { cfg.Prefix = "012345678901234567890" },
+			wantErr: ErrInvalidPrefix,
+		},
+		{
+			name:    "no address family enabled",
+			mutate:  func(cfg *Config) { cfg.GenerateIPv4, cfg.GenerateIPv6 = false, false },
+			wantErr: nil, // checked below, since it isn't one of the sentinel errors
+		},
+	}
+
+	for _, test := range table {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := validConfig()
+			test.mutate(&cfg)
+
+			err := checkConfig(cfg)
+			if test.name == "no address family enabled" {
+				if err == nil {
+					t.Fatal("checkConfig returned nil, want an error")
+				}
+				return
+			}
+
+			if err != test.wantErr {
+				t.Errorf("checkConfig returned %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleAnnounceSkipsWhenNumWantIsZero(t *testing.T) {
+	h, err := New(validConfig())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	resp := &bittorrent.AnnounceResponse{}
+	if _, err := h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{NumWant: 0}, resp); err != nil {
+		t.Fatalf("HandleAnnounce returned error: %v", err)
+	}
+
+	if len(resp.IPv4Peers) != 0 || len(resp.IPv6Peers) != 0 {
+		t.Errorf("HandleAnnounce injected peers despite NumWant == 0: %+v", resp)
+	}
+}
+
+func TestHandleAnnounceCapsAtNumWant(t *testing.T) {
+	cfg := validConfig()
+	cfg.MaxRandomPeers = 50
+
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	req := &bittorrent.AnnounceRequest{NumWant: 5}
+	resp := &bittorrent.AnnounceResponse{
+		IPv4Peers: make([]bittorrent.Peer, 3),
+	}
+
+	if _, err := h.HandleAnnounce(context.Background(), req, resp); err != nil {
+		t.Fatalf("HandleAnnounce returned error: %v", err)
+	}
+
+	total := len(resp.IPv4Peers) + len(resp.IPv6Peers)
+	if total > int(req.NumWant) {
+		t.Errorf("HandleAnnounce returned %d total peers, want at most NumWant (%d)", total, req.NumWant)
+	}
+}
+
+func TestHandleAnnounceSkipsWhenNoRoomLeft(t *testing.T) {
+	h, err := New(validConfig())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	req := &bittorrent.AnnounceRequest{NumWant: 2}
+	resp := &bittorrent.AnnounceResponse{
+		IPv4Peers: make([]bittorrent.Peer, 2),
+	}
+
+	if _, err := h.HandleAnnounce(context.Background(), req, resp); err != nil {
+		t.Fatalf("HandleAnnounce returned error: %v", err)
+	}
+
+	if len(resp.IPv4Peers) != 2 || len(resp.IPv6Peers) != 0 {
+		t.Errorf("HandleAnnounce injected peers despite no room left: %+v", resp)
+	}
+}
+
+func TestRandomPeerIDKeepsPrefixAndFillsSuffix(t *testing.T) {
+	cfg := validConfig()
+	cfg.Prefix = "-CH0001-"
+
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	hk := h.(*hook)
+
+	peer, _, _ := hk.randomPeer(false, 1, 2)
+
+	if got, want := string(peer.ID[:len(cfg.Prefix)]), cfg.Prefix; got != want {
+		t.Errorf("peer ID prefix = %q, want %q", got, want)
+	}
+
+	var allZero = true
+	for _, b := range peer.ID[len(cfg.Prefix):] {
+		if b != 0 {
+			allZero = false
+			break
+		}
+	}
+	if allZero {
+		t.Error("peer ID suffix is all zero, want it filled with random bytes")
+	}
+}
@@ -0,0 +1,21 @@
+package deniability
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/chihaya/chihaya/middleware"
+)
+
+func build(raw yaml.Node) (middleware.Hook, error) {
+	var cfg Config
+	err := raw.Decode(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(cfg)
+}
+
+func init() {
+	middleware.RegisterDriver("deniability", build)
+}
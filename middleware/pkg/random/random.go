@@ -0,0 +1,59 @@
+// Package random provides a small, stateless, deterministic RNG that hooks
+// can thread through a chain of random draws for a single request without
+// sharing a lockable generator. Callers pass the state back in on every
+// call, so a given request always produces the same sequence of values.
+package random
+
+import (
+	"encoding/binary"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+// DeriveEntropyFromRequest derives a pair of xorshift128+ state words from
+// the announcing peer's identity, so a hook can roll several deterministic
+// values (a probability, a count, then that many peers) for the same
+// request without needing per-request synchronization.
+func DeriveEntropyFromRequest(req *bittorrent.AnnounceRequest) (s0, s1 uint64) {
+	s0 = binary.BigEndian.Uint64(req.InfoHash[:8])
+	s1 = binary.BigEndian.Uint64(req.PeerID[:8])
+	if s0 == 0 && s1 == 0 {
+		s1 = 1
+	}
+
+	return s0, s1
+}
+
+// Intn returns a uniformly distributed integer in [0, n), along with the
+// next (s0, s1) state pair to pass to the following call. It returns 0
+// without advancing the state if n is not positive.
+func Intn(s0, s1 uint64, n int) (int, uint64, uint64) {
+	if n <= 0 {
+		return 0, s0, s1
+	}
+
+	const maxUint64 = ^uint64(0)
+	limit := maxUint64 - maxUint64%uint64(n)
+
+	for {
+		var v uint64
+		v, s0, s1 = next(s0, s1)
+		if v < limit {
+			return int(v % uint64(n)), s0, s1
+		}
+	}
+}
+
+// next advances the xorshift128+ generator by one step, returning the
+// generated value and the new state.
+func next(s0, s1 uint64) (v uint64, ns0 uint64, ns1 uint64) {
+	x, y := s0, s1
+	ns0 = y
+
+	x ^= x << 23
+	x ^= x >> 17
+	x ^= y ^ (y >> 26)
+	ns1 = x
+
+	return ns1 + y, ns0, ns1
+}
@@ -0,0 +1,50 @@
+package random
+
+import (
+	"testing"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func TestDeriveEntropyFromRequestIsDeterministic(t *testing.T) {
+	req := &bittorrent.AnnounceRequest{}
+	copy(req.InfoHash[:], "aaaaaaaaaaaaaaaaaaaa")
+	copy(req.PeerID[:], "bbbbbbbbbbbbbbbbbbbb")
+
+	s0a, s1a := DeriveEntropyFromRequest(req)
+	s0b, s1b := DeriveEntropyFromRequest(req)
+
+	if s0a != s0b || s1a != s1b {
+		t.Fatalf("DeriveEntropyFromRequest is not deterministic: (%d, %d) != (%d, %d)", s0a, s1a, s0b, s1b)
+	}
+}
+
+func TestIntnIsWithinBounds(t *testing.T) {
+	req := &bittorrent.AnnounceRequest{}
+	copy(req.InfoHash[:], "aaaaaaaaaaaaaaaaaaaa")
+	copy(req.PeerID[:], "bbbbbbbbbbbbbbbbbbbb")
+
+	s0, s1 := DeriveEntropyFromRequest(req)
+
+	for i := 0; i < 1000; i++ {
+		var v int
+		v, s0, s1 = Intn(s0, s1, 7)
+		if v < 0 || v >= 7 {
+			t.Fatalf("Intn(_, _, 7) = %d, want [0, 7)", v)
+		}
+	}
+}
+
+func TestIntnZeroOrNegativeIsNoop(t *testing.T) {
+	s0, s1 := uint64(1), uint64(2)
+
+	v, ns0, ns1 := Intn(s0, s1, 0)
+	if v != 0 || ns0 != s0 || ns1 != s1 {
+		t.Errorf("Intn(_, _, 0) = (%d, %d, %d), want (0, %d, %d)", v, ns0, ns1, s0, s1)
+	}
+
+	v, ns0, ns1 = Intn(s0, s1, -1)
+	if v != 0 || ns0 != s0 || ns1 != s1 {
+		t.Errorf("Intn(_, _, -1) = (%d, %d, %d), want (0, %d, %d)", v, ns0, ns1, s0, s1)
+	}
+}
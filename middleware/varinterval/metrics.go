@@ -0,0 +1,60 @@
+package varinterval
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+// registerMetrics creates varinterval's collectors and registers them with
+// reg, sizing the added-seconds histogram's buckets around maxDeltaSeconds.
+// If reg already has collectors registered under these names (for example
+// because an earlier hook registered them against the same Registerer), the
+// existing collectors are reused instead of erroring.
+func registerMetrics(reg prometheus.Registerer, maxDeltaSeconds int) (*prometheus.CounterVec, prometheus.Histogram) {
+	bucketWidth := float64(maxDeltaSeconds) / 10
+	if bucketWidth <= 0 {
+		bucketWidth = 1
+	}
+
+	decisionsTotal := mustRegisterOrReuse(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "chihaya_varinterval_decisions_total",
+		Help: "The number of announce responses seen by varinterval, by whether they were modified and by announce event",
+	}, []string{"action", "event"})).(*prometheus.CounterVec)
+
+	addedSeconds := mustRegisterOrReuse(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chihaya_varinterval_added_seconds",
+		Help:    "The distribution of the interval delta added by varinterval, in seconds",
+		Buckets: prometheus.LinearBuckets(bucketWidth, bucketWidth, 10),
+	})).(prometheus.Histogram)
+
+	return decisionsTotal, addedSeconds
+}
+
+// mustRegisterOrReuse registers c with reg, returning the already-registered
+// collector of the same name instead of panicking if one exists.
+func mustRegisterOrReuse(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+
+	if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return are.ExistingCollector
+	}
+
+	panic(err)
+}
+
+func eventLabel(e bittorrent.Event) string {
+	switch e {
+	case bittorrent.Started:
+		return "started"
+	case bittorrent.Stopped:
+		return "stopped"
+	case bittorrent.Completed:
+		return "completed"
+	default:
+		return "none"
+	}
+}
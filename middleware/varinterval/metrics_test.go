@@ -0,0 +1,159 @@
+package varinterval
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/yaml.v3"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func mustYAMLNode(t *testing.T, v interface{}) yaml.Node {
+	t.Helper()
+
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+
+	// yaml.Unmarshal into a Node produces a DocumentNode wrapping the actual
+	// mapping node; unwrap it the way raw.Decode callers expect.
+	if len(node.Content) == 1 {
+		return *node.Content[0]
+	}
+
+	return node
+}
+
+func TestMetricsCountDecisions(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h, err := newHook(Config{
+		ModifyResponseProbability: 1,
+		MaxIncreaseDelta:          60,
+		Metrics:                   true,
+	}, reg)
+	if err != nil {
+		t.Fatalf("newHook returned error: %v", err)
+	}
+
+	events := []bittorrent.Event{bittorrent.Started, bittorrent.None, bittorrent.Completed}
+	for _, event := range events {
+		req := &bittorrent.AnnounceRequest{Event: event}
+		resp := &bittorrent.AnnounceResponse{}
+		if _, err := h.HandleAnnounce(context.Background(), req, resp); err != nil {
+			t.Fatalf("HandleAnnounce returned error: %v", err)
+		}
+	}
+
+	for _, event := range events {
+		got := testutil.ToFloat64(h.decisionsTotal.WithLabelValues("modified", eventLabel(event)))
+		if got != 1 {
+			t.Errorf("decisions_total{action=modified,event=%s} = %v, want 1", eventLabel(event), got)
+		}
+	}
+
+	if got := testutil.CollectAndCount(h.addedSeconds); got != 1 {
+		t.Errorf("added_seconds collected %d metrics, want 1", got)
+	}
+}
+
+func TestMetricsCountUnmodified(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h, err := newHook(Config{
+		// checkConfig rejects a zero probability, so use the resolution of
+		// the hook's roll instead: only a roll of exactly zero modifies the
+		// response, so across enough announces the unmodified path is
+		// certain to be exercised for real.
+		ModifyResponseProbability: 1.0 / (1 << 24),
+		MaxIncreaseDelta:          60,
+		Metrics:                   true,
+	}, reg)
+	if err != nil {
+		t.Fatalf("newHook returned error: %v", err)
+	}
+
+	const maxAnnounces = 10000
+	req := &bittorrent.AnnounceRequest{Event: bittorrent.None}
+	for i := 0; i < maxAnnounces; i++ {
+		resp := &bittorrent.AnnounceResponse{}
+		if _, err := h.HandleAnnounce(context.Background(), req, resp); err != nil {
+			t.Fatalf("HandleAnnounce returned error: %v", err)
+		}
+
+		if testutil.ToFloat64(h.decisionsTotal.WithLabelValues("unmodified", eventLabel(bittorrent.None))) == 1 {
+			return
+		}
+	}
+
+	t.Fatalf("unmodified branch not observed after %d announces", maxAnnounces)
+}
+
+func TestMetricsDisabledTouchesNothing(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h, err := newHook(Config{
+		ModifyResponseProbability: 1,
+		MaxIncreaseDelta:          60,
+	}, reg)
+	if err != nil {
+		t.Fatalf("newHook returned error: %v", err)
+	}
+
+	if _, err := h.HandleAnnounce(context.Background(), &bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{}); err != nil {
+		t.Fatalf("HandleAnnounce returned error: %v", err)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned error: %v", err)
+	}
+	if len(families) != 0 {
+		t.Errorf("expected no metrics registered, got %d families", len(families))
+	}
+}
+
+func TestMetricsBucketsFollowActiveStrategy(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := newHook(Config{
+		ModifyResponseProbability: 1,
+		Strategy:                  "swarmsize",
+		StrategyConfig: mustYAMLNode(t, map[string]int{
+			"max_increase_delta": 600,
+			"peers_per_second":   10,
+		}),
+		Metrics: true,
+	}, reg)
+	if err != nil {
+		t.Fatalf("newHook returned error: %v", err)
+	}
+
+	mf, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather returned error: %v", err)
+	}
+
+	var found bool
+	for _, f := range mf {
+		if f.GetName() != "chihaya_varinterval_added_seconds" {
+			continue
+		}
+		found = true
+		buckets := f.GetMetric()[0].GetHistogram().GetBucket()
+		if len(buckets) == 0 {
+			t.Fatal("histogram has no buckets")
+		}
+		if got, want := buckets[0].GetUpperBound(), 60.0; got != want {
+			t.Errorf("first bucket upper bound = %v, want %v (derived from the 600s strategy, not the zero-value top-level field)", got, want)
+		}
+	}
+	if !found {
+		t.Fatal("chihaya_varinterval_added_seconds not registered")
+	}
+}
@@ -0,0 +1,283 @@
+package varinterval
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+// IntervalStrategy computes how much a HandleAnnounce call should extend the
+// response's interval by. intn returns a uniformly distributed integer in
+// [0, n) drawn from the hook's PCG pool, so strategies that need randomness
+// don't have to manage their own generator.
+type IntervalStrategy interface {
+	Delta(req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse, intn func(n int) int) time.Duration
+}
+
+// scaledStrategy is implemented by every built-in IntervalStrategy to report
+// the maximum delta, in seconds, it was configured with. It's used to size
+// the added-seconds metric's histogram buckets around the strategy that's
+// actually in effect, rather than the legacy top-level MaxIncreaseDelta.
+type scaledStrategy interface {
+	maxDeltaSeconds() int
+}
+
+// StrategyBuilder constructs an IntervalStrategy from its raw YAML config.
+type StrategyBuilder func(raw yaml.Node) (IntervalStrategy, error)
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]StrategyBuilder{
+		"uniform":   newUniformStrategy,
+		"swarmsize": newSwarmSizeStrategy,
+		"eventbias": newEventBiasStrategy,
+		"backoff":   newBackoffStrategy,
+	}
+)
+
+// RegisterStrategy makes an IntervalStrategy available under name for use as
+// the `strategy` YAML key. It is intended to be called from an init()
+// function, mirroring the driver-registration pattern used elsewhere in
+// middleware.
+func RegisterStrategy(name string, ctor StrategyBuilder) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = ctor
+}
+
+func buildStrategy(name string, raw yaml.Node) (IntervalStrategy, error) {
+	strategiesMu.RLock()
+	ctor, ok := strategies[name]
+	strategiesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown interval strategy %q", name)
+	}
+
+	return ctor(raw)
+}
+
+// uniformStrategy is the original varinterval behavior: a flat random delta
+// in [1, MaxIncreaseDelta] seconds.
+type uniformStrategy struct {
+	maxIncreaseDelta int
+}
+
+func newUniformStrategy(raw yaml.Node) (IntervalStrategy, error) {
+	var cfg struct {
+		MaxIncreaseDelta int `yaml:"max_increase_delta"`
+	}
+	if err := raw.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxIncreaseDelta <= 0 {
+		return nil, ErrInvalidMaxIncreaseDelta
+	}
+
+	return &uniformStrategy{maxIncreaseDelta: cfg.MaxIncreaseDelta}, nil
+}
+
+func (s *uniformStrategy) Delta(req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse, intn func(n int) int) time.Duration {
+	return time.Duration(intn(s.maxIncreaseDelta)+1) * time.Second
+}
+
+func (s *uniformStrategy) maxDeltaSeconds() int {
+	return s.maxIncreaseDelta
+}
+
+// swarmSizeStrategy spreads reannounces further apart as a swarm grows, so
+// popular torrents don't hammer the tracker in lockstep.
+type swarmSizeStrategy struct {
+	maxIncreaseDelta int
+	peersPerSecond   int
+}
+
+func newSwarmSizeStrategy(raw yaml.Node) (IntervalStrategy, error) {
+	var cfg struct {
+		MaxIncreaseDelta int `yaml:"max_increase_delta"`
+		PeersPerSecond   int `yaml:"peers_per_second"`
+	}
+	if err := raw.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxIncreaseDelta <= 0 {
+		return nil, ErrInvalidMaxIncreaseDelta
+	}
+	if cfg.PeersPerSecond <= 0 {
+		return nil, errors.New("invalid peers_per_second")
+	}
+
+	return &swarmSizeStrategy{
+		maxIncreaseDelta: cfg.MaxIncreaseDelta,
+		peersPerSecond:   cfg.PeersPerSecond,
+	}, nil
+}
+
+func (s *swarmSizeStrategy) Delta(req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse, intn func(n int) int) time.Duration {
+	swarmSize := int(resp.Complete + resp.Incomplete)
+	addSeconds := 1 + intn(s.maxIncreaseDelta) + swarmSize/s.peersPerSecond
+
+	return time.Duration(addSeconds) * time.Second
+}
+
+func (s *swarmSizeStrategy) maxDeltaSeconds() int {
+	return s.maxIncreaseDelta
+}
+
+// eventBiasStrategy shortens the delta for a Started announce, so new peers
+// get reincorporated into the swarm quickly, and lengthens it for Completed
+// or None announces.
+type eventBiasStrategy struct {
+	maxIncreaseDelta int
+	startedFactor    float64
+	completedFactor  float64
+	noneFactor       float64
+}
+
+func newEventBiasStrategy(raw yaml.Node) (IntervalStrategy, error) {
+	cfg := struct {
+		MaxIncreaseDelta int     `yaml:"max_increase_delta"`
+		StartedFactor    float64 `yaml:"started_factor"`
+		CompletedFactor  float64 `yaml:"completed_factor"`
+		NoneFactor       float64 `yaml:"none_factor"`
+	}{
+		StartedFactor:   0.5,
+		CompletedFactor: 1.5,
+		NoneFactor:      1,
+	}
+	if err := raw.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxIncreaseDelta <= 0 {
+		return nil, ErrInvalidMaxIncreaseDelta
+	}
+	if cfg.StartedFactor <= 0 || cfg.CompletedFactor <= 0 || cfg.NoneFactor <= 0 {
+		return nil, errors.New("invalid event bias factor")
+	}
+
+	return &eventBiasStrategy{
+		maxIncreaseDelta: cfg.MaxIncreaseDelta,
+		startedFactor:    cfg.StartedFactor,
+		completedFactor:  cfg.CompletedFactor,
+		noneFactor:       cfg.NoneFactor,
+	}, nil
+}
+
+func (s *eventBiasStrategy) Delta(req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse, intn func(n int) int) time.Duration {
+	factor := s.noneFactor
+	switch req.Event {
+	case bittorrent.Started:
+		factor = s.startedFactor
+	case bittorrent.Completed:
+		factor = s.completedFactor
+	}
+
+	addSeconds := float64(intn(s.maxIncreaseDelta)+1) * factor
+	return time.Duration(addSeconds * float64(time.Second))
+}
+
+func (s *eventBiasStrategy) maxDeltaSeconds() int {
+	return s.maxIncreaseDelta
+}
+
+// backoffStrategy tracks a shared, atomically-updated EWMA of the announce
+// rate it sees and exponentially scales its delta up once that rate crosses
+// a configured threshold, so a tracker under a reannounce storm pushes
+// clients to back off.
+type backoffStrategy struct {
+	maxIncreaseDelta int
+	threshold        float64
+	multiplier       float64
+
+	lastNanos int64  // atomic
+	rateBits  uint64 // atomic, float64 bits of the current EWMA rate
+}
+
+const backoffEWMAAlpha = 0.2
+
+// backoffMaxScaleMultiple caps how many multiples of maxIncreaseDelta the
+// exponential scale is allowed to push addSeconds to. Without a ceiling,
+// math.Pow grows without bound as the observed rate climbs during exactly
+// the reannounce storm this strategy exists to mitigate, and the resulting
+// float64 overflows into a negative time.Duration on conversion.
+const backoffMaxScaleMultiple = 100
+
+func newBackoffStrategy(raw yaml.Node) (IntervalStrategy, error) {
+	var cfg struct {
+		MaxIncreaseDelta int     `yaml:"max_increase_delta"`
+		Threshold        float64 `yaml:"threshold"`
+		Multiplier       float64 `yaml:"multiplier"`
+	}
+	if err := raw.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxIncreaseDelta <= 0 {
+		return nil, ErrInvalidMaxIncreaseDelta
+	}
+	if cfg.Threshold <= 0 {
+		return nil, errors.New("invalid threshold")
+	}
+	if cfg.Multiplier <= 1 {
+		return nil, errors.New("invalid multiplier")
+	}
+
+	return &backoffStrategy{
+		maxIncreaseDelta: cfg.MaxIncreaseDelta,
+		threshold:        cfg.Threshold,
+		multiplier:       cfg.Multiplier,
+	}, nil
+}
+
+func (s *backoffStrategy) observeRate() float64 {
+	now := time.Now().UnixNano()
+	prev := atomic.SwapInt64(&s.lastNanos, now)
+	if prev == 0 {
+		return math.Float64frombits(atomic.LoadUint64(&s.rateBits))
+	}
+
+	dt := float64(now-prev) / float64(time.Second)
+	if dt <= 0 {
+		dt = 1e-3
+	}
+	instantRate := 1 / dt
+
+	for {
+		old := atomic.LoadUint64(&s.rateBits)
+		oldRate := math.Float64frombits(old)
+		newRate := oldRate + backoffEWMAAlpha*(instantRate-oldRate)
+		if atomic.CompareAndSwapUint64(&s.rateBits, old, math.Float64bits(newRate)) {
+			return newRate
+		}
+	}
+}
+
+func (s *backoffStrategy) Delta(req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse, intn func(n int) int) time.Duration {
+	rate := s.observeRate()
+
+	scale := 1.0
+	if rate > s.threshold {
+		scale = math.Pow(s.multiplier, rate/s.threshold)
+	}
+
+	addSeconds := float64(intn(s.maxIncreaseDelta)+1) * scale
+	if ceiling := float64(s.maxIncreaseDelta * backoffMaxScaleMultiple); addSeconds > ceiling {
+		addSeconds = ceiling
+	}
+
+	return time.Duration(addSeconds * float64(time.Second))
+}
+
+func (s *backoffStrategy) maxDeltaSeconds() int {
+	return s.maxIncreaseDelta
+}
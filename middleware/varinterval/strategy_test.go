@@ -0,0 +1,137 @@
+package varinterval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chihaya/chihaya/bittorrent"
+)
+
+func TestSwarmSizeStrategyDeltaGrowsWithSwarmSize(t *testing.T) {
+	strategy, err := newSwarmSizeStrategy(mustYAMLNode(t, map[string]int{
+		"max_increase_delta": 600,
+		"peers_per_second":   10,
+	}))
+	if err != nil {
+		t.Fatalf("newSwarmSizeStrategy returned error: %v", err)
+	}
+
+	noJitter := func(n int) int { return 0 }
+
+	small := strategy.Delta(&bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{Complete: 10, Incomplete: 10}, noJitter)
+
+	// A swarm this large (peers / peersPerSecond >= maxIncreaseDelta) used to
+	// collapse to a constant, fully clamped delta with no jitter headroom.
+	large := strategy.Delta(&bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{Complete: 3000, Incomplete: 3000}, noJitter)
+
+	if large <= small {
+		t.Fatalf("large swarm delta %v should exceed small swarm delta %v", large, small)
+	}
+
+	wantLarge := time.Duration(1+6000/10) * time.Second
+	if large != wantLarge {
+		t.Errorf("large swarm delta = %v, want %v (uncapped)", large, wantLarge)
+	}
+}
+
+func TestUniformStrategyDelta(t *testing.T) {
+	strategy := &uniformStrategy{maxIncreaseDelta: 60}
+
+	if got, want := strategy.Delta(&bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{}, func(n int) int { return 0 }), time.Second; got != want {
+		t.Errorf("Delta with no jitter = %v, want %v", got, want)
+	}
+
+	if got, want := strategy.Delta(&bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{}, func(n int) int { return n - 1 }), 60*time.Second; got != want {
+		t.Errorf("Delta with max jitter = %v, want %v", got, want)
+	}
+}
+
+func TestEventBiasStrategyRejectsNonPositiveFactors(t *testing.T) {
+	_, err := newEventBiasStrategy(mustYAMLNode(t, map[string]interface{}{
+		"max_increase_delta": 60,
+		"none_factor":        -1,
+	}))
+	if err == nil {
+		t.Fatal("newEventBiasStrategy accepted a negative none_factor, want an error")
+	}
+}
+
+func TestEventBiasStrategyDelta(t *testing.T) {
+	strategy, err := newEventBiasStrategy(mustYAMLNode(t, map[string]interface{}{
+		"max_increase_delta": 60,
+		"started_factor":     0.5,
+		"completed_factor":   1.5,
+		"none_factor":        1.0,
+	}))
+	if err != nil {
+		t.Fatalf("newEventBiasStrategy returned error: %v", err)
+	}
+
+	maxJitter := func(n int) int { return n - 1 }
+
+	started := strategy.Delta(&bittorrent.AnnounceRequest{Event: bittorrent.Started}, &bittorrent.AnnounceResponse{}, maxJitter)
+	none := strategy.Delta(&bittorrent.AnnounceRequest{Event: bittorrent.None}, &bittorrent.AnnounceResponse{}, maxJitter)
+	completed := strategy.Delta(&bittorrent.AnnounceRequest{Event: bittorrent.Completed}, &bittorrent.AnnounceResponse{}, maxJitter)
+
+	if started >= none || none >= completed {
+		t.Errorf("Delta should grow started < none < completed, got started=%v none=%v completed=%v", started, none, completed)
+	}
+
+	if want := time.Duration(60*1.5) * time.Second; completed != want {
+		t.Errorf("completed Delta = %v, want %v", completed, want)
+	}
+}
+
+func TestBackoffStrategyDeltaStaysWithinBaseRangeBelowThreshold(t *testing.T) {
+	strategy, err := newBackoffStrategy(mustYAMLNode(t, map[string]interface{}{
+		"max_increase_delta": 60,
+		"threshold":          1e9, // unreachable, so scale should never engage
+		"multiplier":         2,
+	}))
+	if err != nil {
+		t.Fatalf("newBackoffStrategy returned error: %v", err)
+	}
+
+	noJitter := func(n int) int { return 0 }
+	for i := 0; i < 10; i++ {
+		got := strategy.Delta(&bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{}, noJitter)
+		if got != time.Second {
+			t.Fatalf("Delta below threshold = %v, want %v", got, time.Second)
+		}
+	}
+}
+
+func TestBackoffStrategyDeltaClampsUnderSustainedStorm(t *testing.T) {
+	strategy, err := newBackoffStrategy(mustYAMLNode(t, map[string]interface{}{
+		"max_increase_delta": 60,
+		"threshold":          0.001, // trivially exceeded by a tight loop's instantaneous rate
+		"multiplier":         2,
+	}))
+	if err != nil {
+		t.Fatalf("newBackoffStrategy returned error: %v", err)
+	}
+
+	noJitter := func(n int) int { return 0 }
+	ceiling := time.Duration(60*backoffMaxScaleMultiple) * time.Second
+
+	var sawScaledUp bool
+	for i := 0; i < 1000; i++ {
+		got := strategy.Delta(&bittorrent.AnnounceRequest{}, &bittorrent.AnnounceResponse{}, noJitter)
+
+		// This is the bug from the review: an unclamped exponential scale
+		// converts to a negative time.Duration on overflow.
+		if got <= 0 {
+			t.Fatalf("Delta on iteration %d = %v, want a positive duration", i, got)
+		}
+		if got > ceiling {
+			t.Fatalf("Delta on iteration %d = %v, want at most the ceiling %v", i, got, ceiling)
+		}
+		if got > time.Duration(60)*time.Second {
+			sawScaledUp = true
+		}
+	}
+
+	if !sawScaledUp {
+		t.Fatal("backoff never scaled past the base range; test didn't exercise the exponential branch")
+	}
+}
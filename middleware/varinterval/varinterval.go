@@ -2,13 +2,17 @@ package varinterval
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"runtime"
 	"sync"
-	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
 
 	"github.com/chihaya/chihaya/bittorrent"
 	"github.com/chihaya/chihaya/middleware"
-	"github.com/chihaya/chihaya/middleware/pkg/random"
 )
 
 // ErrInvalidModifyResponseProbability is returned for a config with an invalid
@@ -31,6 +35,21 @@ type Config struct {
 	// ModifyMinInterval specifies whether min_interval should be increased
 	// as well.
 	ModifyMinInterval bool `yaml:"modify_min_interval"`
+
+	// Strategy selects the IntervalStrategy used to compute the delta added
+	// to the interval. An empty value means "uniform", preserving the
+	// original behavior driven by MaxIncreaseDelta.
+	Strategy string `yaml:"strategy"`
+
+	// StrategyConfig holds strategy-specific settings, interpreted according
+	// to Strategy. Strategies other than the default "uniform" read their
+	// own fields out of this node.
+	StrategyConfig yaml.Node `yaml:"strategy_config"`
+
+	// Metrics specifies whether this middleware should report Prometheus
+	// metrics. Leaving this disabled avoids registering anything with the
+	// default Prometheus registry, for embedders who don't run one.
+	Metrics bool `yaml:"metrics"`
 }
 
 func checkConfig(cfg Config) error {
@@ -38,41 +57,138 @@ func checkConfig(cfg Config) error {
 		return ErrInvalidModifyResponseProbability
 	}
 
-	if cfg.MaxIncreaseDelta <= 0 {
+	// An empty Strategy, or an explicit "uniform" with no strategy-specific
+	// overrides, falls back to the legacy top-level MaxIncreaseDelta, so it
+	// must be validated here too; otherwise newUniformStrategy validates it.
+	usesLegacyUniform := cfg.Strategy == "" || cfg.Strategy == "uniform"
+	if usesLegacyUniform && cfg.StrategyConfig.Kind == 0 && cfg.MaxIncreaseDelta <= 0 {
 		return ErrInvalidMaxIncreaseDelta
 	}
 
 	return nil
 }
 
+// pcgShard is a single PCG-XSH-RR 64->32 generator. Each shard is only ever
+// handed out to one goroutine at a time via hook.pool, so it needs no
+// synchronization of its own.
+type pcgShard struct {
+	state uint64
+	inc   uint64
+}
+
+// newPCGShard seeds a shard from crypto/rand. inc must be odd for the PCG
+// stream to have full period.
+func newPCGShard() *pcgShard {
+	var seed [16]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+
+	return &pcgShard{
+		state: binary.BigEndian.Uint64(seed[:8]),
+		inc:   binary.BigEndian.Uint64(seed[8:]) | 1,
+	}
+}
+
+func (s *pcgShard) next() uint32 {
+	s.state = s.state*6364136223846793005 + s.inc
+	xorshifted := uint32(((s.state >> 18) ^ s.state) >> 27)
+	rot := uint32(s.state >> 59)
+	return (xorshifted >> rot) | (xorshifted << ((-rot) & 31))
+}
+
 type hook struct {
-	cfg Config
-	sync.Mutex
+	cfg      Config
+	pool     sync.Pool
+	strategy IntervalStrategy
+
+	decisionsTotal *prometheus.CounterVec
+	addedSeconds   prometheus.Histogram
 }
 
 // New creates a middleware to randomly modify the announce interval from the
 // given config.
 func New(cfg Config) (middleware.Hook, error) {
+	h, err := newHook(cfg, prometheus.DefaultRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// newHook is New with an injectable Registerer, so tests can scrape an
+// isolated prometheus.NewRegistry() instead of the global default one.
+func newHook(cfg Config, reg prometheus.Registerer) (*hook, error) {
 	err := checkConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	h := &hook{
-		cfg: cfg,
+	h := &hook{cfg: cfg}
+	h.pool.New = func() interface{} {
+		return newPCGShard()
 	}
+
+	shards := runtime.GOMAXPROCS(0)
+	if shards < 1 {
+		shards = 1
+	}
+	for i := 0; i < shards; i++ {
+		h.pool.Put(newPCGShard())
+	}
+
+	name := cfg.Strategy
+	if name == "" {
+		name = "uniform"
+	}
+	if name == "uniform" && cfg.StrategyConfig.Kind == 0 {
+		h.strategy = &uniformStrategy{maxIncreaseDelta: cfg.MaxIncreaseDelta}
+	} else {
+		h.strategy, err = buildStrategy(name, cfg.StrategyConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Metrics {
+		maxDeltaSeconds := cfg.MaxIncreaseDelta
+		if s, ok := h.strategy.(scaledStrategy); ok {
+			maxDeltaSeconds = s.maxDeltaSeconds()
+		}
+		h.decisionsTotal, h.addedSeconds = registerMetrics(reg, maxDeltaSeconds)
+	}
+
 	return h, nil
 }
 
+// intn returns a uniformly distributed integer in [0, n) using rejection
+// sampling against a shard drawn from the pool, avoiding the modulo bias a
+// plain `next() % n` would introduce.
+func (h *hook) intn(n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	shard := h.pool.Get().(*pcgShard)
+	defer h.pool.Put(shard)
+
+	const maxUint32 = 1<<32 - 1
+	limit := uint32(maxUint32) - uint32(maxUint32)%uint32(n)
+	for {
+		v := shard.next()
+		if v < limit {
+			return int(v % uint32(n))
+		}
+	}
+}
+
 func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceRequest, resp *bittorrent.AnnounceResponse) (context.Context, error) {
-	s0, s1 := random.DeriveEntropyFromRequest(req)
 	// Generate a probability p < 1.0.
-	v, s0, s1 := random.Intn(s0, s1, 1<<24)
+	v := h.intn(1 << 24)
 	p := float32(v) / (1 << 24)
 	if h.cfg.ModifyResponseProbability == 1 || p < h.cfg.ModifyResponseProbability {
-		// Generate the increase delta.
-		v, _, _ = random.Intn(s0, s1, h.cfg.MaxIncreaseDelta)
-		addSeconds := time.Duration(v+1) * time.Second
+		addSeconds := h.strategy.Delta(req, resp, h.intn)
 
 		resp.Interval += addSeconds
 
@@ -80,9 +196,18 @@ func (h *hook) HandleAnnounce(ctx context.Context, req *bittorrent.AnnounceReque
 			resp.MinInterval += addSeconds
 		}
 
+		if h.cfg.Metrics {
+			h.decisionsTotal.WithLabelValues("modified", eventLabel(req.Event)).Inc()
+			h.addedSeconds.Observe(addSeconds.Seconds())
+		}
+
 		return ctx, nil
 	}
 
+	if h.cfg.Metrics {
+		h.decisionsTotal.WithLabelValues("unmodified", eventLabel(req.Event)).Inc()
+	}
+
 	return ctx, nil
 }
 
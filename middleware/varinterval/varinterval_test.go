@@ -0,0 +1,49 @@
+package varinterval
+
+import (
+	"testing"
+
+	"github.com/chihaya/chihaya/bittorrent"
+	"github.com/chihaya/chihaya/middleware/pkg/random"
+)
+
+func newBenchHook(b *testing.B) *hook {
+	b.Helper()
+
+	h, err := newHook(Config{
+		ModifyResponseProbability: 1,
+		MaxIncreaseDelta:          60,
+	}, nil)
+	if err != nil {
+		b.Fatalf("newHook returned error: %v", err)
+	}
+
+	return h
+}
+
+// BenchmarkPCGPoolIntn measures the throughput of the lock-free PCG pool
+// that replaced the per-request entropy-derivation path.
+func BenchmarkPCGPoolIntn(b *testing.B) {
+	h := newBenchHook(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.intn(1 << 24)
+		}
+	})
+}
+
+// BenchmarkEntropyDerivation measures the throughput of the approach the PCG
+// pool replaced: deriving entropy from the announce request on every call.
+func BenchmarkEntropyDerivation(b *testing.B) {
+	req := &bittorrent.AnnounceRequest{}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s0, s1 := random.DeriveEntropyFromRequest(req)
+			random.Intn(s0, s1, 1<<24)
+		}
+	})
+}